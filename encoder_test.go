@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func sampleElement() CodeElement {
+	return CodeElement{
+		Name:      "Bar",
+		Signature: "func Bar()",
+		CodeType:  "function",
+		Docstring: "Bar does a thing.",
+		Line:      4,
+		LineFrom:  4,
+		LineTo:    6,
+		Context:   map[string]string{"file_path": "pkg/mod.go"},
+	}
+}
+
+func encodeElements(t *testing.T, enc Encoder, elements ...CodeElement) string {
+	t.Helper()
+	ch := make(chan CodeElement, len(elements))
+	for _, e := range elements {
+		ch <- e
+	}
+	close(ch)
+
+	var buf bytes.Buffer
+	if _, err := enc.Encode(&buf, ch); err != nil {
+		t.Fatalf("Encode returned error: %v", err)
+	}
+	return buf.String()
+}
+
+// TestLsifEncoder_EmitsDocumentRangeAndEdgesForFilePath locks in the vertex/
+// edge wiring lsifEncoder hand-builds for a single CodeElement: a document
+// vertex keyed by Context["file_path"], a range vertex with the element's
+// (zero-based, clamped) line span, and an item/contains edge pair tying the
+// range back into the definitionResult and the document.
+func TestLsifEncoder_EmitsDocumentRangeAndEdgesForFilePath(t *testing.T) {
+	out := encodeElements(t, lsifEncoder{}, sampleElement())
+
+	var docID, rangeID, defResultID float64
+	var sawDocument, sawRange, sawItemEdge, sawContainsEdge bool
+
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("failed to unmarshal LSIF line %q: %v", line, err)
+		}
+
+		switch v["label"] {
+		case "document":
+			sawDocument = true
+			docID = v["id"].(float64)
+			if uri := v["uri"]; uri != "file://pkg/mod.go" {
+				t.Fatalf("document uri = %v, want file://pkg/mod.go", uri)
+			}
+		case "range":
+			sawRange = true
+			rangeID = v["id"].(float64)
+			start := v["start"].(map[string]interface{})
+			end := v["end"].(map[string]interface{})
+			if start["line"].(float64) != 4 {
+				t.Fatalf("range start.line = %v, want 4 (LineFrom)", start["line"])
+			}
+			if end["line"].(float64) != 6 {
+				t.Fatalf("range end.line = %v, want 6 (LineTo)", end["line"])
+			}
+		case "definitionResult":
+			defResultID = v["id"].(float64)
+		case "item":
+			sawItemEdge = true
+			if v["outV"].(float64) != defResultID {
+				t.Fatalf("item edge outV = %v, want definitionResult id %v", v["outV"], defResultID)
+			}
+			inVs := v["inVs"].([]interface{})
+			if len(inVs) != 1 || inVs[0].(float64) != rangeID {
+				t.Fatalf("item edge inVs = %v, want [%v]", inVs, rangeID)
+			}
+			if v["document"].(float64) != docID {
+				t.Fatalf("item edge document = %v, want document id %v", v["document"], docID)
+			}
+		case "contains":
+			sawContainsEdge = true
+			if v["outV"].(float64) != docID {
+				t.Fatalf("contains edge outV = %v, want document id %v", v["outV"], docID)
+			}
+			inVs := v["inVs"].([]interface{})
+			if len(inVs) != 1 || inVs[0].(float64) != rangeID {
+				t.Fatalf("contains edge inVs = %v, want [%v]", inVs, rangeID)
+			}
+		}
+	}
+
+	if !sawDocument || !sawRange || !sawItemEdge || !sawContainsEdge {
+		t.Fatalf("missing expected LSIF vertices/edges: document=%v range=%v item=%v contains=%v",
+			sawDocument, sawRange, sawItemEdge, sawContainsEdge)
+	}
+}
+
+// TestLsifEncoder_ReusesDocumentVertexForSameFilePath regresses re-emitting a
+// document vertex per element: two elements from the same file must share
+// one document vertex, not get one each.
+func TestLsifEncoder_ReusesDocumentVertexForSameFilePath(t *testing.T) {
+	a := sampleElement()
+	b := sampleElement()
+	b.Name = "Baz"
+	b.LineFrom, b.LineTo = 8, 9
+
+	out := encodeElements(t, lsifEncoder{}, a, b)
+
+	documentCount := 0
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("failed to unmarshal LSIF line %q: %v", line, err)
+		}
+		if v["label"] == "document" {
+			documentCount++
+		}
+	}
+	if documentCount != 1 {
+		t.Fatalf("emitted %d document vertices for two elements sharing a file_path, want 1", documentCount)
+	}
+}
+
+// TestSarifEncoder_MapsCodeElementToResult locks in sarifEncoder's mapping
+// from CodeElement fields to the SARIF result/rule/location shape.
+func TestSarifEncoder_MapsCodeElementToResult(t *testing.T) {
+	out := encodeElements(t, sarifEncoder{}, sampleElement())
+
+	var doc struct {
+		Runs []struct {
+			Tool struct {
+				Driver struct {
+					Name  string `json:"name"`
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID  string `json:"ruleId"`
+				Message struct {
+					Text string `json:"text"`
+				} `json:"message"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+						Region struct {
+							StartLine int32 `json:"startLine"`
+							EndLine   int32 `json:"endLine"`
+						} `json:"region"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("failed to unmarshal SARIF output: %v", err)
+	}
+
+	if len(doc.Runs) != 1 {
+		t.Fatalf("len(runs) = %d, want 1", len(doc.Runs))
+	}
+	run := doc.Runs[0]
+	if len(run.Tool.Driver.Rules) != 1 || run.Tool.Driver.Rules[0].ID != "function" {
+		t.Fatalf("rules = %v, want one rule with id \"function\"", run.Tool.Driver.Rules)
+	}
+	if len(run.Results) != 1 {
+		t.Fatalf("len(results) = %d, want 1", len(run.Results))
+	}
+	result := run.Results[0]
+	if result.RuleID != "function" {
+		t.Fatalf("result.ruleId = %q, want \"function\"", result.RuleID)
+	}
+	if result.Message.Text != "function: Bar" {
+		t.Fatalf("result.message.text = %q, want \"function: Bar\"", result.Message.Text)
+	}
+	if len(result.Locations) != 1 {
+		t.Fatalf("len(locations) = %d, want 1", len(result.Locations))
+	}
+	loc := result.Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != "pkg/mod.go" {
+		t.Fatalf("artifactLocation.uri = %q, want \"pkg/mod.go\"", loc.ArtifactLocation.URI)
+	}
+	if loc.Region.StartLine != 5 || loc.Region.EndLine != 7 {
+		t.Fatalf("region = {%d,%d}, want {5,7} (1-based LineFrom/LineTo)", loc.Region.StartLine, loc.Region.EndLine)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/scip/bindings/go/scip"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestReadVarint(t *testing.T) {
+	cases := []struct {
+		name  string
+		bytes []byte
+		want  uint64
+	}{
+		{"single byte", []byte{0x01}, 1},
+		{"max single byte", []byte{0x7f}, 127},
+		{"two bytes", []byte{0x96, 0x01}, 150},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readVarint(bufio.NewReader(strings.NewReader(string(tc.bytes))))
+			if err != nil {
+				t.Fatalf("readVarint returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("readVarint(%v) = %d, want %d", tc.bytes, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReadVarint_OverflowsSixtyFourBits(t *testing.T) {
+	// Ten continuation bytes (0x80) push the shift past 64 bits before the
+	// trailing 0x01 terminator is reached.
+	bytes := []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}
+	if _, err := readVarint(bufio.NewReader(strings.NewReader(string(bytes)))); err == nil {
+		t.Fatalf("readVarint did not reject a varint overflowing 64 bits")
+	}
+}
+
+// encodeLengthDelimitedField hand-frames a single protobuf length-delimited
+// field (tag + varint length + payload), the same wire format IndexReader
+// walks.
+func encodeLengthDelimitedField(fieldNum int, payload []byte) []byte {
+	var buf []byte
+	buf = appendVarint(buf, uint64(fieldNum)<<3|2)
+	buf = appendVarint(buf, uint64(len(payload)))
+	buf = append(buf, payload...)
+	return buf
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func TestIndexReader_Visit_StreamsDocuments(t *testing.T) {
+	doc := &scip.Document{RelativePath: "foo.go"}
+	payload, err := proto.Marshal(doc)
+	if err != nil {
+		t.Fatalf("proto.Marshal(doc) returned error: %v", err)
+	}
+
+	var raw []byte
+	raw = append(raw, encodeLengthDelimitedField(1, []byte("metadata, skipped"))...)
+	raw = append(raw, encodeLengthDelimitedField(scipIndexDocumentsField, payload)...)
+
+	var got []string
+	err = NewIndexReader(strings.NewReader(string(raw))).Visit(func(d *scip.Document) error {
+		got = append(got, d.RelativePath)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Visit returned error: %v", err)
+	}
+	if len(got) != 1 || got[0] != "foo.go" {
+		t.Fatalf("Visit visited %v, want [\"foo.go\"]", got)
+	}
+}
+
+func TestIndexReader_Visit_RejectsOversizedLength(t *testing.T) {
+	var raw []byte
+	raw = appendVarint(raw, uint64(scipIndexDocumentsField)<<3|2)
+	raw = appendVarint(raw, maxScipFieldLength+1)
+	// No payload bytes needed: the length check must fail before Visit tries
+	// to read (or allocate for) the payload.
+
+	err := NewIndexReader(strings.NewReader(string(raw))).Visit(func(*scip.Document) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("Visit did not reject a field length exceeding maxScipFieldLength")
+	}
+}
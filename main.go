@@ -1,13 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"container/list"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/sourcegraph/scip/bindings/go/scip"
 	"google.golang.org/protobuf/proto"
@@ -23,71 +30,249 @@ type CodeElement struct {
 	LineFrom  int32             `json:"line_from"`
 	LineTo    int32             `json:"line_to"`
 	Context   map[string]string `json:"context"`
+	Symbol    *Symbol           `json:"symbol,omitempty"`
+	Callers   []SymbolRef       `json:"callers,omitempty"`
+	Callees   []SymbolRef       `json:"callees,omitempty"`
+}
+
+// SymbolRef identifies one site that refers to a symbol: its display name
+// and the file/line of the reference.
+type SymbolRef struct {
+	Name     string `json:"name"`
+	FilePath string `json:"file_path"`
+	Line     int32  `json:"line"`
+}
+
+// OccurrenceSite is one occurrence of a symbol anywhere in the index,
+// definition or reference, as recorded in the references.json sidecar.
+type OccurrenceSite struct {
+	FilePath string `json:"file_path"`
+	Line     int32  `json:"line"`
+	Role     string `json:"role"`
+}
+
+// Symbol is a structured decomposition of a SCIP symbol string, analogous to
+// what debug/gosym.Sym exposes via PackageName/ReceiverName/BaseName. It is
+// derived by walking the descriptor list produced by scip.ParseSymbol rather
+// than by guessing at positional string offsets.
+type Symbol struct {
+	Scheme         string   `json:"scheme"`
+	Manager        string   `json:"manager,omitempty"`
+	PackageName    string   `json:"package_name,omitempty"`
+	Version        string   `json:"version,omitempty"`
+	Namespaces     []string `json:"namespaces,omitempty"`
+	Receiver       string   `json:"receiver,omitempty"`
+	Name           string   `json:"name"`
+	TypeParameters []string `json:"type_parameters,omitempty"`
 }
 
 func main() {
 	// Parse command-line flags for input and output files.
 	inputFile := flag.String("input", "index.scip", "Path to the input SCIP index file")
 	outputFile := flag.String("output", "structure.json", "Path to the output JSON file")
+	referencesFile := flag.String("references", "references.json", "Path to the output references sidecar file")
+	format := flag.String("format", "json", "Output format: json, ndjson, lsif, or sarif")
+	workers := flag.Int("workers", 4, "Number of concurrent workers extracting snippets")
+	cacheBytes := flag.Int64("file-cache-bytes", 256*1024*1024, "Maximum bytes of source text to keep cached for snippet extraction")
 	flag.Parse()
 
-	// Read and parse the SCIP index file.
-	index, err := readSCIPIndex(*inputFile)
+	encoder, err := encoderForFormat(*format)
 	if err != nil {
-		log.Fatalf("Error reading SCIP index: %v", err)
+		log.Fatalf("Error selecting output format: %v", err)
 	}
 
-	// Process the index to extract code elements.
-	elements := processIndex(index)
+	fileTextCache = newLRUFileCache(*cacheBytes)
 
-	// Write the extracted elements to the output JSON file.
-	if err := writeJSONOutput(*outputFile, elements); err != nil {
-		log.Fatalf("Error writing JSON output: %v", err)
+	// First streaming pass: build the symbol -> occurrence graph used for call hierarchy and
+	// the references sidecar. Documents are decoded and discarded one at a time, so this pass
+	// doesn't hold the whole proto in memory at once the way the original proto.Unmarshal did -
+	// but the resulting referenceIndex itself still grows with the number of occurrences in the
+	// index as a whole (bounded per symbol, see referenceIndex's doc comment), so it is not a
+	// full fix for indices whose occurrence count alone is too large to hold in memory.
+	refs, err := buildReferenceIndex(*inputFile)
+	if err != nil {
+		log.Fatalf("Error building reference index: %v", err)
+	}
+	for symbol, dropped := range refs.truncated {
+		log.Printf("Warning: %d occurrence/call-edge entries dropped for symbol %s (exceeded per-symbol cap)", dropped, symbol)
 	}
 
-	fmt.Printf("Successfully generated %s with %d code elements\n", *outputFile, len(elements))
-}
+	// Second streaming pass: a worker pool extracts snippets/context per document concurrently,
+	// while the selected Encoder writes each CodeElement out as it arrives.
+	elements, readErrCh := processIndex(*inputFile, refs, *workers)
 
-// readSCIPIndex reads and unmarshals the SCIP index from the given file.
-func readSCIPIndex(filename string) (*scip.Index, error) {
-	data, err := os.ReadFile(filename)
+	out, err := os.Create(*outputFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read SCIP file: %w", err)
+		log.Fatalf("Error creating output file: %v", err)
 	}
+	defer out.Close()
 
-	var index scip.Index
-	if err := proto.Unmarshal(data, &index); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal SCIP index: %w", err)
+	count, err := encoder.Encode(out, elements)
+	if err != nil {
+		log.Fatalf("Error writing %s output: %v", *format, err)
+	}
+	if err := <-readErrCh; err != nil {
+		log.Fatalf("Error reading SCIP index: %v", err)
+	}
+
+	// Write the inverted symbol -> occurrence-sites index alongside it.
+	if err := writeReferencesOutput(*referencesFile, refs.occurrences); err != nil {
+		log.Fatalf("Error writing references output: %v", err)
 	}
 
-	return &index, nil
+	fmt.Printf("Successfully generated %s with %d code elements\n", *outputFile, count)
 }
 
-// processIndex iterates through the SCIP index to extract code elements.
-func processIndex(index *scip.Index) []CodeElement {
-	var elements []CodeElement
+// IndexReader decodes a scip.Index one scip.Document at a time, so callers
+// never hold the whole index in memory. A SCIP index file is, at the top
+// level, just a sequence of length-delimited protobuf fields; IndexReader
+// walks that framing manually (tag, varint length, payload) and only calls
+// proto.Unmarshal on the `documents` field's payload; other fields' payloads
+// are read and discarded without being decoded.
+type IndexReader struct {
+	br *bufio.Reader
+}
 
-	for _, doc := range index.Documents {
-		for _, sym := range doc.Symbols {
-			element, err := processSymbol(doc, sym)
-			if err != nil {
-				log.Printf("Skipping symbol due to error: %v", err)
-				continue
-			}
+// NewIndexReader wraps r for streaming document-by-document decoding.
+func NewIndexReader(r io.Reader) *IndexReader {
+	return &IndexReader{br: bufio.NewReaderSize(r, 64*1024)}
+}
 
-			if element.CodeType == "Unknown" {
-				continue
-			}
+// scipIndexDocumentsField is the protobuf field number of
+// scip.Index.documents (`repeated Document documents = 2`).
+const scipIndexDocumentsField = 2
+
+// maxScipFieldLength bounds a single length-delimited field's payload. No
+// real SCIP document is anywhere near this large; it exists to turn a
+// truncated or corrupt index file into a clean error instead of an
+// out-of-memory allocation or a "makeslice: len out of range" panic.
+const maxScipFieldLength = 1 << 30 // 1 GiB
+
+// Visit decodes the index field-by-field, calling fn once per scip.Document
+// in order. It stops and returns fn's error if fn returns one.
+func (r *IndexReader) Visit(fn func(*scip.Document) error) error {
+	for {
+		tag, err := readVarint(r.br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read field tag: %w", err)
+		}
+
+		fieldNum := tag >> 3
+		wireType := tag & 0x7
+		if wireType != 2 {
+			return fmt.Errorf("unexpected wire type %d for field %d", wireType, fieldNum)
+		}
 
-			elements = append(elements, element)
+		length, err := readVarint(r.br)
+		if err != nil {
+			return fmt.Errorf("failed to read length for field %d: %w", fieldNum, err)
+		}
+		if length > maxScipFieldLength {
+			return fmt.Errorf("field %d length %d exceeds max of %d (corrupt or truncated index?)", fieldNum, length, maxScipFieldLength)
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r.br, payload); err != nil {
+			return fmt.Errorf("failed to read payload for field %d: %w", fieldNum, err)
+		}
+
+		if fieldNum != scipIndexDocumentsField {
+			continue // metadata and external_symbols aren't needed for extraction
+		}
+
+		var doc scip.Document
+		if err := proto.Unmarshal(payload, &doc); err != nil {
+			return fmt.Errorf("failed to unmarshal document: %w", err)
+		}
+		if err := fn(&doc); err != nil {
+			return err
 		}
 	}
+}
 
-	return elements
+// readVarint reads a single protobuf base-128 varint from r.
+func readVarint(r *bufio.Reader) (uint64, error) {
+	var result uint64
+	var shift uint
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+		shift += 7
+		if shift >= 64 {
+			return 0, fmt.Errorf("varint overflows 64 bits")
+		}
+	}
+}
+
+// processIndex streams the SCIP index at path a second time and fans each
+// document out to a pool of workers that extract CodeElements concurrently.
+// It returns the elements as they're produced over a channel, plus a channel
+// that carries the single error from the streaming read (nil on success),
+// closed once that error (or lack of one) is known.
+func processIndex(path string, refs *referenceIndex, workers int) (<-chan CodeElement, <-chan error) {
+	docs := make(chan *scip.Document, workers)
+	elements := make(chan CodeElement, workers)
+	readErrCh := make(chan error, 1)
+
+	go func() {
+		defer close(docs)
+		defer close(readErrCh)
+
+		f, err := os.Open(path)
+		if err != nil {
+			readErrCh <- fmt.Errorf("failed to open SCIP index: %w", err)
+			return
+		}
+		defer f.Close()
+
+		if err := NewIndexReader(f).Visit(func(doc *scip.Document) error {
+			docs <- doc
+			return nil
+		}); err != nil {
+			readErrCh <- fmt.Errorf("failed to read SCIP index: %w", err)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for doc := range docs {
+				for _, sym := range doc.Symbols {
+					element, err := processSymbol(doc, sym, refs)
+					if err != nil {
+						log.Printf("Skipping symbol due to error: %v", err)
+						continue
+					}
+					if element.CodeType == "Unknown" {
+						continue
+					}
+					elements <- element
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(elements)
+	}()
+
+	return elements, readErrCh
 }
 
 // processSymbol extracts information from a SymbolInformation object to create a CodeElement.
-func processSymbol(doc *scip.Document, sym *scip.SymbolInformation) (CodeElement, error) {
+func processSymbol(doc *scip.Document, sym *scip.SymbolInformation, refs *referenceIndex) (CodeElement, error) {
 	log.Printf("Processing symbol: %s in file: %s", sym.Symbol, doc.RelativePath)
 
 	line, lineFrom, lineTo, snippet, err := getSnippet(doc, sym)
@@ -96,29 +281,92 @@ func processSymbol(doc *scip.Document, sym *scip.SymbolInformation) (CodeElement
 		// Proceeding without snippet
 	}
 
+	parsed, err := parseSymbol(sym.Symbol)
+	if err != nil {
+		log.Printf("Warning: Could not parse symbol %s: %v", sym.Symbol, err)
+	}
+
 	return CodeElement{
-		Name:      extractName(sym.Symbol),
+		Name:      symbolName(parsed, sym.Symbol),
 		Signature: extractSignature(sym),
 		CodeType:  extractCodeType(sym),
 		Docstring: extractDocstring(sym),
 		Line:      line,
 		LineFrom:  lineFrom,
 		LineTo:    lineTo,
-		Context:   extractContext(doc, sym, snippet),
+		Context:   extractContext(doc, parsed, snippet),
+		Symbol:    parsed,
+		Callers:   refs.callers[sym.Symbol],
+		Callees:   refs.callees[sym.Symbol],
 	}, nil
 }
 
-// extractName parses the symbol string to extract the symbol's name.
-func extractName(symbol string) string {
-	// Example symbol format: "kind package.module.name"
-	parts := strings.Split(symbol, " ")
-	if len(parts) > 1 {
-		// Assuming the last part is the fully qualified name
-		fullName := parts[len(parts)-1]
-		nameParts := strings.Split(fullName, ".")
-		return nameParts[len(nameParts)-1]
+// parseSymbol decomposes a SCIP symbol string ("scheme manager package-name
+// version descriptors...") into its typed components by walking the
+// descriptor list returned by scip.ParseSymbol, rather than splitting on
+// spaces and dots. Descriptor suffixes carry the sigil that identified them
+// in the original string (`#` for types, `().` for methods, `[]` for type
+// parameters, `:` for meta), so the enclosing namespace, receiver/type, and
+// member name can be recovered precisely for any language's scheme.
+func parseSymbol(symbolStr string) (*Symbol, error) {
+	parsed, err := scip.ParseSymbol(symbolStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse symbol %q: %w", symbolStr, err)
+	}
+
+	out := &Symbol{Scheme: parsed.Scheme}
+	if pkg := parsed.Package; pkg != nil {
+		out.Manager = pkg.Manager
+		out.PackageName = pkg.Name
+		out.Version = pkg.Version
+	}
+
+	descriptors := parsed.Descriptors
+	for i, desc := range descriptors {
+		last := i == len(descriptors)-1
+		switch desc.Suffix {
+		case scip.Descriptor_Namespace:
+			out.Namespaces = append(out.Namespaces, desc.Name)
+		case scip.Descriptor_Type:
+			if last {
+				out.Name = desc.Name
+			} else {
+				out.Namespaces = append(out.Namespaces, desc.Name)
+			}
+		case scip.Descriptor_TypeParameter:
+			out.TypeParameters = append(out.TypeParameters, desc.Name)
+		case scip.Descriptor_Term, scip.Descriptor_Method:
+			if !last {
+				out.Namespaces = append(out.Namespaces, desc.Name)
+				continue
+			}
+			out.Name = desc.Name
+			// A preceding Type descriptor is the receiver for a method or
+			// the enclosing type for a term (e.g. a Rust `impl` block).
+			if i > 0 && descriptors[i-1].Suffix == scip.Descriptor_Type {
+				out.Receiver = descriptors[i-1].Name
+			}
+		default:
+			if last {
+				out.Name = desc.Name
+			}
+		}
+	}
+
+	if out.Name == "" && len(descriptors) > 0 {
+		out.Name = descriptors[len(descriptors)-1].Name
+	}
+
+	return out, nil
+}
+
+// symbolName returns the display name for a CodeElement, falling back to the
+// raw symbol string when parsing failed.
+func symbolName(parsed *Symbol, rawSymbol string) string {
+	if parsed != nil && parsed.Name != "" {
+		return parsed.Name
 	}
-	return symbol
+	return rawSymbol
 }
 
 // extractSignature retrieves the signature from the SignatureDocumentation field.
@@ -340,22 +588,23 @@ func extractLineTo(doc *scip.Document, sym *scip.SymbolInformation) int32 {
 	return 0
 }
 
-// extractContext gathers additional context information for the code element.
-func extractContext(doc *scip.Document, sym *scip.SymbolInformation, snippet string) map[string]string {
+// extractContext gathers additional context information for the code element
+// from the parsed symbol's typed fields, rather than positional string
+// indexing into the raw symbol.
+func extractContext(doc *scip.Document, parsed *Symbol, snippet string) map[string]string {
 	ctx := map[string]string{
 		"file_path": doc.RelativePath,
 		"file_name": filepath.Base(doc.RelativePath),
 	}
 
-	parts := strings.Split(sym.Symbol, " ")
-	if len(parts) > 3 {
-		ctx["module"] = parts[3]
-	}
-
-	for i, part := range parts {
-		if part == "impl" && i+1 < len(parts) {
-			ctx["struct_name"] = parts[i+1]
-			break
+	if parsed != nil {
+		if parsed.PackageName != "" {
+			ctx["module"] = parsed.PackageName
+		}
+		if parsed.Receiver != "" {
+			ctx["struct_name"] = parsed.Receiver
+		} else if len(parsed.Namespaces) > 0 {
+			ctx["struct_name"] = parsed.Namespaces[len(parsed.Namespaces)-1]
 		}
 	}
 
@@ -367,7 +616,9 @@ func extractContext(doc *scip.Document, sym *scip.SymbolInformation, snippet str
 }
 
 // getSnippet extracts the code snippet from the document based on the symbol's range.
-// It adjusts for zero-based indexing and attempts to find the end of multi-line symbols.
+// occ.Range is already zero-based (per scip.Occurrence's own doc comment), so
+// it's used directly; this function only attempts to find the end of
+// multi-line symbols when the occurrence itself doesn't span one.
 func getSnippet(doc *scip.Document, sym *scip.SymbolInformation) (line int32, lineFrom int32, lineTo int32, snippet string, err error) {
 	var startLine, endLine int32
 
@@ -389,8 +640,8 @@ func getSnippet(doc *scip.Document, sym *scip.SymbolInformation) (line int32, li
 		return 0, 0, 0, "", fmt.Errorf("definition occurrence not found or invalid range")
 	}
 
-	startLineZero := startLine - 1
-	endLineZero := endLine - 1
+	startLineZero := startLine
+	endLineZero := endLine
 
 	if startLineZero < 0 {
 		log.Printf("Warning: startLineZero (%d) is less than 0. Adjusting to 0.", startLineZero)
@@ -412,7 +663,8 @@ func getSnippet(doc *scip.Document, sym *scip.SymbolInformation) (line int32, li
 	}
 
 	if endLineZero == startLineZero {
-		detectedEndLine, err := findEndLine(lines, int(startLineZero))
+		resolver := resolverForPath(doc.RelativePath)
+		detectedEndLine, err := resolver.ResolveEndLine(doc.RelativePath, text, lines, int(startLineZero))
 		if err != nil {
 			log.Printf("Warning: Could not determine end line for symbol %s: %v", sym.Symbol, err)
 			detectedEndLine = int(startLineZero)
@@ -439,17 +691,83 @@ func getSnippet(doc *scip.Document, sym *scip.SymbolInformation) (line int32, li
 	return
 }
 
-// getText retrieves the text from doc.Text or reads it from the file path.
-// It also caches the file content to optimize performance.
-var fileCache = make(map[string]string)
+// fileTextCache caches source text by relative path for snippet extraction,
+// bounded by total bytes rather than entry count so it can't grow without
+// limit on an index spanning a huge monorepo. Replaced in main() once flags
+// are parsed; workers access it concurrently, so it's safe for concurrent use.
+var fileTextCache = newLRUFileCache(256 * 1024 * 1024)
+
+// lruFileCache is a byte-bounded, concurrency-safe LRU cache of file text.
+type lruFileCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	curBytes int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruFileCacheEntry struct {
+	key  string
+	text string
+}
+
+func newLRUFileCache(maxBytes int64) *lruFileCache {
+	return &lruFileCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *lruFileCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruFileCacheEntry).text, true
+}
+
+func (c *lruFileCache) Set(key, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruFileCacheEntry)
+		c.curBytes += int64(len(text)) - int64(len(entry.text))
+		entry.text = text
+		c.ll.MoveToFront(el)
+	} else {
+		el := c.ll.PushFront(&lruFileCacheEntry{key: key, text: text})
+		c.items[key] = el
+		c.curBytes += int64(len(text))
+	}
+
+	for c.curBytes > c.maxBytes {
+		back := c.ll.Back()
+		if back == nil || back == c.ll.Front() {
+			break
+		}
+		entry := back.Value.(*lruFileCacheEntry)
+		c.ll.Remove(back)
+		delete(c.items, entry.key)
+		c.curBytes -= int64(len(entry.text))
+	}
+}
 
+// getText retrieves the text from doc.Text or reads it from the file path,
+// consulting fileTextCache first to avoid re-reading the same file for
+// multiple symbols.
 func getText(doc *scip.Document) (string, error) {
-	if text, exists := fileCache[doc.RelativePath]; exists {
+	if text, ok := fileTextCache.Get(doc.RelativePath); ok {
 		return text, nil
 	}
 
 	if len(doc.Text) > 0 {
-		fileCache[doc.RelativePath] = doc.Text
+		fileTextCache.Set(doc.RelativePath, doc.Text)
 		return doc.Text, nil
 	}
 
@@ -462,35 +780,703 @@ func getText(doc *scip.Document) (string, error) {
 		return "", fmt.Errorf("unable to read file %s: %w", absPath, err)
 	}
 	text := string(fileData)
-	fileCache[doc.RelativePath] = text
+	fileTextCache.Set(doc.RelativePath, text)
 	return text, nil
 }
 
-// findEndLine attempts to find the end line of a symbol by matching braces.
-// This is a simplistic approach and may need enhancements for complex cases.
-func findEndLine(lines []string, start int) (int, error) {
-	openBraces := 0
+// RangeResolver determines the zero-based end line of the definition that
+// starts at a given zero-based line, using language-specific structure
+// instead of guessing from characters alone. resolverForPath picks an
+// implementation by file extension; getSnippet falls back to startLine on
+// error so callers always get a usable (possibly single-line) range. path
+// identifies the document (for resolvers that cache parsed state per file,
+// such as goRangeResolver's AST cache) and is otherwise unused.
+type RangeResolver interface {
+	ResolveEndLine(path, text string, lines []string, startLine int) (int, error)
+}
+
+// resolverForPath returns the RangeResolver appropriate for a file's
+// extension, falling back to a string/comment-aware brace counter for
+// brace-delimited languages that don't yet have a dedicated AST binding.
+func resolverForPath(path string) RangeResolver {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".go":
+		return goRangeResolver{}
+	case ".py", ".pyi":
+		return indentRangeResolver{}
+	default:
+		return braceRangeResolver{}
+	}
+}
+
+// goRangeResolver walks the Go AST to find the FuncDecl, GenDecl, or
+// TypeSpec enclosing the start line and returns its End() position, so
+// multi-line signatures and braces inside string/rune literals or comments
+// don't throw off the detected range.
+type goRangeResolver struct{}
+
+// goASTCache memoizes the parsed AST per document path, the same idea as
+// fileTextCache for source text, so a file with N symbols is parsed once
+// instead of once per symbol (ResolveEndLine runs concurrently across the
+// processIndex worker pool, so this must be safe for concurrent use).
+var (
+	goASTCacheMu sync.Mutex
+	goASTCache   = make(map[string]*goASTCacheEntry)
+)
+
+type goASTCacheEntry struct {
+	fset *token.FileSet
+	file *ast.File
+	err  error
+}
+
+func parsedGoFile(path, text string) (*token.FileSet, *ast.File, error) {
+	goASTCacheMu.Lock()
+	defer goASTCacheMu.Unlock()
+
+	if entry, ok := goASTCache[path]; ok {
+		return entry.fset, entry.file, entry.err
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", text, parser.ParseComments)
+	goASTCache[path] = &goASTCacheEntry{fset: fset, file: file, err: err}
+	return fset, file, err
+}
+
+func (goRangeResolver) ResolveEndLine(path, text string, lines []string, startLine int) (int, error) {
+	fset, file, err := parsedGoFile(path, text)
+	if err != nil {
+		return startLine, fmt.Errorf("failed to parse Go source: %w", err)
+	}
+
+	findDeclEnd := func(wantLine int) token.Pos {
+		var end token.Pos
+		ast.Inspect(file, func(n ast.Node) bool {
+			if n == nil || end != token.NoPos {
+				return false
+			}
+			switch decl := n.(type) {
+			case *ast.FuncDecl:
+				if fset.Position(decl.Pos()).Line == wantLine {
+					end = decl.End()
+				}
+			case *ast.GenDecl:
+				if fset.Position(decl.Pos()).Line == wantLine {
+					end = decl.End()
+				}
+			case *ast.TypeSpec:
+				if fset.Position(decl.Pos()).Line == wantLine {
+					end = decl.End()
+				}
+			}
+			return true
+		})
+		return end
+	}
+
+	startTokenLine := startLine + 1 // go/token lines are 1-based
+
+	// If no declaration starts on startLine, also try the next line before
+	// giving up, so a startLine one before the declaration still resolves.
+	end := findDeclEnd(startTokenLine)
+	if end == token.NoPos {
+		end = findDeclEnd(startTokenLine + 1)
+	}
+
+	if end == token.NoPos {
+		return startLine, fmt.Errorf("no enclosing declaration found at line %d or %d", startTokenLine, startTokenLine+1)
+	}
+
+	return fset.Position(end).Line - 1, nil
+}
+
+// indentRangeResolver finds the end of an indentation-delimited block (e.g.
+// Python) by scanning forward for the first non-blank line whose indentation
+// is no deeper than the start line's.
+type indentRangeResolver struct{}
+
+func (indentRangeResolver) ResolveEndLine(path, text string, lines []string, startLine int) (int, error) {
+	if startLine >= len(lines) {
+		return startLine, fmt.Errorf("start line %d out of range", startLine)
+	}
+
+	baseIndent := leadingWhitespaceLen(lines[startLine])
+	end := startLine
+
+	for i := startLine + 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" {
+			continue
+		}
+		if leadingWhitespaceLen(lines[i]) <= baseIndent {
+			break
+		}
+		end = i
+	}
+
+	return end, nil
+}
+
+func leadingWhitespaceLen(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// braceRangeResolver is the fallback for brace-delimited languages without a
+// dedicated AST binding. It counts braces like the original heuristic but
+// skips over string, rune, and comment contents so braces embedded in those
+// (quoted text, attribute macros, `//` or `/* */` comments) don't throw off
+// the count. Both block-comment and open-quote state are carried across
+// lines, so a multi-line string or raw literal containing `{`/`}` doesn't
+// corrupt the depth count either.
+type braceRangeResolver struct{}
+
+func (braceRangeResolver) ResolveEndLine(path, text string, lines []string, start int) (int, error) {
+	depth := 0
+	var inBlockComment bool
+	var inQuote rune
+
 	for i := start; i < len(lines); i++ {
-		line := lines[i]
-		openBraces += strings.Count(line, "{")
-		openBraces -= strings.Count(line, "}")
-		if openBraces <= 0 && i != start {
+		depth += braceDelta(lines[i], &inBlockComment, &inQuote)
+		if depth <= 0 && i != start {
 			return i, nil
 		}
 	}
 	return len(lines) - 1, fmt.Errorf("could not find closing brace")
 }
 
+// braceDelta counts the net `{`/`}` in a single line, skipping over string
+// and rune literals and `//`/`/* */` comments. inBlockComment and inQuote
+// carry, respectively, block-comment and open-quote state across lines, so
+// a literal that doesn't close before the line ends is resumed (and its
+// contents skipped) on the next call instead of leaking its braces into the
+// count.
+func braceDelta(line string, inBlockComment *bool, inQuote *rune) int {
+	delta := 0
+	runes := []rune(line)
+	i := 0
+
+	if *inQuote != 0 {
+		quote := *inQuote
+		for i < len(runes) && runes[i] != quote {
+			if runes[i] == '\\' && quote != '`' {
+				i++
+			}
+			i++
+		}
+		if i >= len(runes) {
+			return delta // literal still open at end of line
+		}
+		*inQuote = 0
+		i++ // past the closing quote
+	}
+
+	for ; i < len(runes); i++ {
+		if *inBlockComment {
+			if i+1 < len(runes) && runes[i] == '*' && runes[i+1] == '/' {
+				*inBlockComment = false
+				i++
+			}
+			continue
+		}
+
+		switch runes[i] {
+		case '/':
+			if i+1 < len(runes) && runes[i+1] == '/' {
+				return delta
+			}
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				*inBlockComment = true
+				i++
+			}
+		case '"', '\'', '`':
+			quote := runes[i]
+			i++
+			for i < len(runes) && runes[i] != quote {
+				if runes[i] == '\\' && quote != '`' {
+					i++
+				}
+				i++
+			}
+			if i >= len(runes) {
+				*inQuote = quote
+				return delta
+			}
+		case '{':
+			delta++
+		case '}':
+			delta--
+		}
+	}
+
+	return delta
+}
+
 // hasRole checks if the given symbolRoles bitmask includes the targetRole.
 func hasRole(symbolRoles int32, targetRole scip.SymbolRole) bool {
 	return symbolRoles&int32(targetRole) != 0
 }
 
-// writeJSONOutput writes the extracted code elements to a JSON file.
-func writeJSONOutput(filename string, elements []CodeElement) error {
+// referenceIndex holds the symbol graph built from a pass over
+// doc.Occurrences: who calls whom (callers/callees, keyed by the callee's
+// and caller's raw symbol strings respectively) and the full inverted index
+// of every occurrence site per symbol, for the references.json sidecar.
+//
+// This index lives entirely in memory and grows with the number of
+// occurrences in the index as a whole, not with any single document - it is
+// not itself a fix for indices whose occurrence count is too large to hold
+// in memory, only for the prior whole-proto-in-one-Unmarshal behavior. To
+// keep one hot symbol (e.g. a widely-called logging helper) from growing
+// its entry without bound, each symbol's slices are capped at
+// maxOccurrenceSitesPerSymbol / maxCallEdgesPerSymbol; sites beyond the cap
+// are dropped and counted in truncated, so the drop is logged rather than
+// silent.
+type referenceIndex struct {
+	callers     map[string][]SymbolRef
+	callees     map[string][]SymbolRef
+	occurrences map[string][]OccurrenceSite
+	truncated   map[string]int
+}
+
+// Per-symbol caps on referenceIndex's accumulated slices. These bound the
+// memory a single hot symbol can consume; they do not bound the index as a
+// whole, which still scales with the number of distinct symbols in the index.
+const (
+	maxOccurrenceSitesPerSymbol = 2000
+	maxCallEdgesPerSymbol       = 500
+)
+
+// appendBounded appends to *sites up to limit entries, and otherwise counts
+// the drop in ref.truncated keyed by symbol so main can log it instead of
+// it being silently lost.
+func (ref *referenceIndex) appendBounded(sites *[]OccurrenceSite, symbol string, limit int, site OccurrenceSite) {
+	if len(*sites) >= limit {
+		ref.truncated[symbol]++
+		return
+	}
+	*sites = append(*sites, site)
+}
+
+// appendBoundedRef is appendBounded for the []SymbolRef caller/callee slices.
+func (ref *referenceIndex) appendBoundedRef(refs *[]SymbolRef, symbol string, limit int, site SymbolRef) {
+	if len(*refs) >= limit {
+		ref.truncated[symbol]++
+		return
+	}
+	*refs = append(*refs, site)
+}
+
+// defSite is a definition's location within a single document, used to
+// attribute a reference occurrence to its innermost enclosing symbol.
+type defSite struct {
+	symbol             string
+	startLine, endLine int32
+}
+
+// buildReferenceIndex streams the SCIP index at path once to build the
+// caller/callee graph and the full symbol -> occurrence-sites index, via
+// IndexReader, so this pass also never holds more than one document at a
+// time in memory.
+func buildReferenceIndex(path string) (*referenceIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SCIP index: %w", err)
+	}
+	defer f.Close()
+
+	ref := &referenceIndex{
+		callers:     make(map[string][]SymbolRef),
+		callees:     make(map[string][]SymbolRef),
+		occurrences: make(map[string][]OccurrenceSite),
+		truncated:   make(map[string]int),
+	}
+
+	if err := NewIndexReader(f).Visit(ref.addDocument); err != nil {
+		return nil, fmt.Errorf("failed to read SCIP index: %w", err)
+	}
+
+	return ref, nil
+}
+
+// addDocument folds one document's occurrences into the reference index. A
+// reference occurrence is attributed to whichever definition's range in the
+// *same* document (narrowest one wins, for nested definitions) contains it:
+// that definition is a caller of the referenced symbol, and the referenced
+// symbol is a callee of that definition. This mirrors what
+// internal/lsp/source's call hierarchy support derives from gopls' index,
+// but built from SCIP occurrences instead of gopls' own AST index. Because
+// attribution never crosses documents, this can run one document at a time.
+func (ref *referenceIndex) addDocument(doc *scip.Document) error {
+	var defSites []defSite
+	for _, occ := range doc.Occurrences {
+		if !hasRole(occ.SymbolRoles, scip.SymbolRole_Definition) || len(occ.Range) < 2 {
+			continue
+		}
+		start := occ.Range[0]
+		end := start
+		if len(occ.Range) >= 4 {
+			end = occ.Range[2]
+		}
+		defSites = append(defSites, defSite{occ.Symbol, start, end})
+	}
+
+	enclosingSymbol := func(line int32) string {
+		best := ""
+		bestSpan := int32(-1)
+		for _, d := range defSites {
+			if line < d.startLine || line > d.endLine {
+				continue
+			}
+			if span := d.endLine - d.startLine; best == "" || span < bestSpan {
+				best, bestSpan = d.symbol, span
+			}
+		}
+		return best
+	}
+
+	for _, occ := range doc.Occurrences {
+		if len(occ.Range) == 0 {
+			continue
+		}
+		line := occ.Range[0]
+		isDefinition := hasRole(occ.SymbolRoles, scip.SymbolRole_Definition)
+
+		role := "reference"
+		if isDefinition {
+			role = "definition"
+		}
+		occSites := ref.occurrences[occ.Symbol]
+		ref.appendBounded(&occSites, occ.Symbol, maxOccurrenceSitesPerSymbol, OccurrenceSite{
+			FilePath: doc.RelativePath,
+			Line:     line,
+			Role:     role,
+		})
+		ref.occurrences[occ.Symbol] = occSites
+
+		if isDefinition {
+			continue
+		}
+
+		caller := enclosingSymbol(line)
+		if caller == "" || caller == occ.Symbol {
+			continue
+		}
+
+		callees := ref.callees[caller]
+		ref.appendBoundedRef(&callees, caller, maxCallEdgesPerSymbol, SymbolRef{
+			Name:     symbolDisplayName(occ.Symbol),
+			FilePath: doc.RelativePath,
+			Line:     line,
+		})
+		ref.callees[caller] = callees
+
+		callers := ref.callers[occ.Symbol]
+		ref.appendBoundedRef(&callers, occ.Symbol, maxCallEdgesPerSymbol, SymbolRef{
+			Name:     symbolDisplayName(caller),
+			FilePath: doc.RelativePath,
+			Line:     line,
+		})
+		ref.callers[occ.Symbol] = callers
+	}
+
+	return nil
+}
+
+// symbolDisplayName returns the human-readable name for a raw symbol string,
+// falling back to the symbol itself if it cannot be parsed.
+func symbolDisplayName(symbolStr string) string {
+	parsed, err := parseSymbol(symbolStr)
+	if err != nil {
+		return symbolStr
+	}
+	return symbolName(parsed, symbolStr)
+}
+
+// Encoder writes a stream of CodeElements to w in some output format and
+// reports how many it wrote. Implementations consume elements as they
+// arrive rather than requiring the full slice, so the streaming pipeline in
+// processIndex isn't forced to buffer everything in memory to encode it.
+type Encoder interface {
+	Encode(w io.Writer, elements <-chan CodeElement) (int, error)
+}
+
+// encoderForFormat resolves the -format flag to an Encoder. "json" (also
+// selected by an empty format) is the default and keeps the original
+// indented top-level array for backward compatibility.
+func encoderForFormat(format string) (Encoder, error) {
+	switch format {
+	case "", "json":
+		return jsonArrayEncoder{}, nil
+	case "ndjson":
+		return ndjsonEncoder{}, nil
+	case "lsif":
+		return lsifEncoder{}, nil
+	case "sarif":
+		return sarifEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q (want json, ndjson, lsif, or sarif)", format)
+	}
+}
+
+// jsonArrayEncoder streams the extracted code elements out as a single
+// indented top-level JSON array, writing each element as it arrives on the
+// channel rather than buffering the whole slice, so peak memory tracks one
+// CodeElement instead of the full result set. This is the original,
+// default output format.
+type jsonArrayEncoder struct{}
+
+func (jsonArrayEncoder) Encode(w io.Writer, elements <-chan CodeElement) (int, error) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	encoder := json.NewEncoder(bw)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("  ", "  ")
+
+	if _, err := bw.WriteString("[\n"); err != nil {
+		return 0, fmt.Errorf("failed to write JSON output: %w", err)
+	}
+
+	count := 0
+	for element := range elements {
+		if count > 0 {
+			if _, err := bw.WriteString(",\n"); err != nil {
+				return count, fmt.Errorf("failed to write JSON output: %w", err)
+			}
+		}
+		if _, err := bw.WriteString("  "); err != nil {
+			return count, fmt.Errorf("failed to write JSON output: %w", err)
+		}
+		if err := encoder.Encode(element); err != nil {
+			return count, fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		count++
+	}
+
+	if _, err := bw.WriteString("]\n"); err != nil {
+		return count, fmt.Errorf("failed to write JSON output: %w", err)
+	}
+
+	return count, nil
+}
+
+// ndjsonEncoder writes one CodeElement per line, with no enclosing array, so
+// the output can be streamed or diffed line-by-line by indexers and RAG
+// ingestion pipelines.
+type ndjsonEncoder struct{}
+
+func (ndjsonEncoder) Encode(w io.Writer, elements <-chan CodeElement) (int, error) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	encoder := json.NewEncoder(bw)
+	encoder.SetEscapeHTML(false)
+
+	count := 0
+	for element := range elements {
+		if err := encoder.Encode(element); err != nil {
+			return count, fmt.Errorf("failed to encode NDJSON line: %w", err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// lsifEncoder converts each CodeElement into the equivalent LSIF vertices
+// and edges (document, range, resultSet, hoverResult, definitionResult) so
+// the output can be loaded into existing LSIF consumers. It emits one LSIF
+// element per line, as the LSIF spec requires.
+type lsifEncoder struct{}
+
+func (lsifEncoder) Encode(w io.Writer, elements <-chan CodeElement) (int, error) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	encoder := json.NewEncoder(bw)
+	encoder.SetEscapeHTML(false)
+
+	nextID := 0
+	newID := func() int {
+		nextID++
+		return nextID
+	}
+
+	emit := func(v map[string]interface{}) error {
+		return encoder.Encode(v)
+	}
+
+	if err := emit(map[string]interface{}{
+		"id": newID(), "type": "vertex", "label": "metaData",
+		"version": "0.4.3", "projectRoot": "file:///", "positionEncoding": "utf-16",
+	}); err != nil {
+		return 0, fmt.Errorf("failed to write LSIF metaData vertex: %w", err)
+	}
+
+	docIDs := make(map[string]int)
+	count := 0
+
+	for element := range elements {
+		path := element.Context["file_path"]
+		docID, ok := docIDs[path]
+		if !ok {
+			docID = newID()
+			docIDs[path] = docID
+			if err := emit(map[string]interface{}{
+				"id": docID, "type": "vertex", "label": "document", "uri": "file://" + path,
+			}); err != nil {
+				return count, fmt.Errorf("failed to write LSIF document vertex: %w", err)
+			}
+		}
+
+		// CodeElement lines are SCIP's zero-based lines, as LSIF's are.
+		startLine := element.LineFrom
+		if startLine < 0 {
+			startLine = 0
+		}
+		endLine := element.LineTo
+		if endLine < startLine {
+			endLine = startLine
+		}
+
+		rangeID := newID()
+		if err := emit(map[string]interface{}{
+			"id": rangeID, "type": "vertex", "label": "range",
+			"start": map[string]int32{"line": startLine, "character": 0},
+			"end":   map[string]int32{"line": endLine, "character": 0},
+		}); err != nil {
+			return count, fmt.Errorf("failed to write LSIF range vertex: %w", err)
+		}
+
+		resultSetID := newID()
+		if err := emit(map[string]interface{}{"id": resultSetID, "type": "vertex", "label": "resultSet"}); err != nil {
+			return count, fmt.Errorf("failed to write LSIF resultSet vertex: %w", err)
+		}
+		if err := emit(map[string]interface{}{"id": newID(), "type": "edge", "label": "next", "outV": rangeID, "inV": resultSetID}); err != nil {
+			return count, fmt.Errorf("failed to write LSIF next edge: %w", err)
+		}
+
+		var contents []string
+		if element.Signature != "" {
+			contents = append(contents, element.Signature)
+		}
+		if element.Docstring != "" {
+			contents = append(contents, element.Docstring)
+		}
+		hoverID := newID()
+		if err := emit(map[string]interface{}{
+			"id": hoverID, "type": "vertex", "label": "hoverResult",
+			"result": map[string]interface{}{"contents": contents},
+		}); err != nil {
+			return count, fmt.Errorf("failed to write LSIF hoverResult vertex: %w", err)
+		}
+		if err := emit(map[string]interface{}{"id": newID(), "type": "edge", "label": "textDocument/hover", "outV": resultSetID, "inV": hoverID}); err != nil {
+			return count, fmt.Errorf("failed to write LSIF hover edge: %w", err)
+		}
+
+		defResultID := newID()
+		if err := emit(map[string]interface{}{"id": defResultID, "type": "vertex", "label": "definitionResult"}); err != nil {
+			return count, fmt.Errorf("failed to write LSIF definitionResult vertex: %w", err)
+		}
+		if err := emit(map[string]interface{}{"id": newID(), "type": "edge", "label": "textDocument/definition", "outV": resultSetID, "inV": defResultID}); err != nil {
+			return count, fmt.Errorf("failed to write LSIF definition edge: %w", err)
+		}
+		if err := emit(map[string]interface{}{"id": newID(), "type": "edge", "label": "item", "outV": defResultID, "inVs": []int{rangeID}, "document": docID}); err != nil {
+			return count, fmt.Errorf("failed to write LSIF item edge: %w", err)
+		}
+		if err := emit(map[string]interface{}{"id": newID(), "type": "edge", "label": "contains", "outV": docID, "inVs": []int{rangeID}}); err != nil {
+			return count, fmt.Errorf("failed to write LSIF contains edge: %w", err)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// sarifEncoder renders CodeElements as SARIF results, one per code element,
+// so output can be consumed by tools that already understand code-scanning
+// results. Unlike the other encoders, SARIF is a single JSON document rather
+// than a streamable sequence, so results are buffered (without their
+// snippets) until the channel closes and then encoded once.
+type sarifEncoder struct{}
+
+type sarifRegion struct {
+	StartLine int32 `json:"startLine"`
+	EndLine   int32 `json:"endLine"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region sarifRegion `json:"region"`
+	} `json:"physicalLocation"`
+}
+
+type sarifResult struct {
+	RuleID  string `json:"ruleId"`
+	Message struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+func (sarifEncoder) Encode(w io.Writer, elements <-chan CodeElement) (int, error) {
+	seenRules := make(map[string]bool)
+	var rules []map[string]string
+	var results []sarifResult
+
+	for element := range elements {
+		if !seenRules[element.CodeType] {
+			seenRules[element.CodeType] = true
+			rules = append(rules, map[string]string{"id": element.CodeType, "name": element.CodeType})
+		}
+
+		result := sarifResult{RuleID: element.CodeType}
+		result.Message.Text = fmt.Sprintf("%s: %s", element.CodeType, element.Name)
+		var loc sarifLocation
+		loc.PhysicalLocation.ArtifactLocation.URI = element.Context["file_path"]
+		// SARIF regions are 1-based; CodeElement lines are zero-based.
+		loc.PhysicalLocation.Region = sarifRegion{StartLine: element.LineFrom + 1, EndLine: element.LineTo + 1}
+		result.Locations = []sarifLocation{loc}
+		results = append(results, result)
+	}
+
+	log := map[string]interface{}{
+		"$schema": "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []map[string]interface{}{
+			{
+				"tool": map[string]interface{}{
+					"driver": map[string]interface{}{
+						"name":  "scipex",
+						"rules": rules,
+					},
+				},
+				"results": results,
+			},
+		},
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(log); err != nil {
+		return len(results), fmt.Errorf("failed to encode SARIF output: %w", err)
+	}
+
+	return len(results), nil
+}
+
+// writeReferencesOutput writes the inverted symbol -> occurrence-sites index
+// to a JSON sidecar file, so downstream tools (RAG, code-review bots) can
+// navigate the reference graph without re-reading the SCIP file.
+func writeReferencesOutput(filename string, occurrences map[string][]OccurrenceSite) error {
 	file, err := os.Create(filename)
 	if err != nil {
-		return fmt.Errorf("failed to create JSON output file: %w", err)
+		return fmt.Errorf("failed to create references output file: %w", err)
 	}
 	defer file.Close()
 
@@ -498,8 +1484,8 @@ func writeJSONOutput(filename string, elements []CodeElement) error {
 	encoder.SetEscapeHTML(false)
 	encoder.SetIndent("", "  ")
 
-	if err := encoder.Encode(elements); err != nil {
-		return fmt.Errorf("failed to encode JSON: %w", err)
+	if err := encoder.Encode(occurrences); err != nil {
+		return fmt.Errorf("failed to encode references JSON: %w", err)
 	}
 
 	return nil
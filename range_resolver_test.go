@@ -0,0 +1,173 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/scip/bindings/go/scip"
+)
+
+// TestGoRangeResolver_ResolveEndLine_ZeroBasedSCIPLine exercises the exact
+// scenario getSnippet hits in practice: a 0-based SCIP occurrence line for a
+// top-level func decl. It must resolve to the line of the closing brace, not
+// fall back to a single-line snippet.
+func TestGoRangeResolver_ResolveEndLine_ZeroBasedSCIPLine(t *testing.T) {
+	text := "package p\n\nfunc Bar() {\n\tdoStuff()\n}\n"
+	lines := []string{
+		"package p",
+		"",
+		"func Bar() {",
+		"\tdoStuff()",
+		"}",
+	}
+
+	// "func Bar() {" is 0-based line 2.
+	got, err := (goRangeResolver{}).ResolveEndLine("zerobased.go", text, lines, 2)
+	if err != nil {
+		t.Fatalf("ResolveEndLine returned error: %v", err)
+	}
+	if want := 4; got != want {
+		t.Fatalf("ResolveEndLine(startLine=2) = %d, want %d", got, want)
+	}
+}
+
+// TestGoRangeResolver_ResolveEndLine_OffByOneFromGetSnippet regresses the
+// bug where getSnippet handed the resolver a line one earlier than the
+// declaration's true 0-based line (it used to subtract one from the SCIP
+// line). The resolver must tolerate such a start line and still find the
+// enclosing declaration instead of silently falling back to a single-line
+// snippet.
+func TestGoRangeResolver_ResolveEndLine_OffByOneFromGetSnippet(t *testing.T) {
+	text := "package p\n\nfunc Bar() {\n\tdoStuff()\n}\n"
+	lines := []string{
+		"package p",
+		"",
+		"func Bar() {",
+		"\tdoStuff()",
+		"}",
+	}
+
+	// One line before the real 0-based declaration line (2).
+	got, err := (goRangeResolver{}).ResolveEndLine("offbyone.go", text, lines, 1)
+	if err != nil {
+		t.Fatalf("ResolveEndLine returned error: %v", err)
+	}
+	if want := 4; got != want {
+		t.Fatalf("ResolveEndLine(startLine=1) = %d, want %d", got, want)
+	}
+}
+
+// TestParsedGoFile_CachesPerPath regresses re-parsing the same file once per
+// symbol: a second call for the same path must return the cached AST rather
+// than reparsing, even if (as here) the text argument changes.
+func TestParsedGoFile_CachesPerPath(t *testing.T) {
+	const path = "cached.go"
+	text1 := "package p\n\nfunc A() {}\n"
+	text2 := "package p\n\nfunc A() {}\nfunc B() {}\n"
+
+	_, file1, err := parsedGoFile(path, text1)
+	if err != nil {
+		t.Fatalf("parsedGoFile (first call) returned error: %v", err)
+	}
+	_, file2, err := parsedGoFile(path, text2)
+	if err != nil {
+		t.Fatalf("parsedGoFile (second call) returned error: %v", err)
+	}
+	if file1 != file2 {
+		t.Fatalf("parsedGoFile returned a different *ast.File for the same path on the second call, want the cached one")
+	}
+}
+
+// TestBraceDelta_MultilineQuoteDoesNotLeakBraces regresses string/rune state
+// not carrying across lines: a backtick literal that opens on one line and
+// closes on the next must not have its embedded `{`/`}` (nor the stray `}`
+// preceding the close) counted as real braces, and the real code following
+// the close must still be counted.
+func TestBraceDelta_MultilineQuoteDoesNotLeakBraces(t *testing.T) {
+	line1 := "s := `a { b { c"
+	line2 := "d } e` f { g }"
+
+	var inBlockComment bool
+	var inQuote rune
+
+	delta1 := braceDelta(line1, &inBlockComment, &inQuote)
+	if delta1 != 0 {
+		t.Fatalf("braceDelta(line1) = %d, want 0 (braces inside the open string must not count)", delta1)
+	}
+	if inQuote != '`' {
+		t.Fatalf("inQuote after line1 = %q, want '`' (the backtick literal is still open)", inQuote)
+	}
+
+	delta2 := braceDelta(line2, &inBlockComment, &inQuote)
+	if delta2 != 0 {
+		t.Fatalf("braceDelta(line2) = %d, want 0 (the stray '}' before the close must be skipped, and 'f { g }' nets to zero)", delta2)
+	}
+	if inQuote != 0 {
+		t.Fatalf("inQuote after line2 = %q, want 0 (the backtick literal closed on this line)", inQuote)
+	}
+}
+
+// definitionOccurrence builds a minimal definition scip.Occurrence for the
+// given 0-based line, the shape getSnippet scans doc.Occurrences for.
+func definitionOccurrence(symbol string, line int32) *scip.Occurrence {
+	return &scip.Occurrence{
+		Symbol:      symbol,
+		SymbolRoles: int32(scip.SymbolRole_Definition),
+		Range:       []int32{line, 0, 10},
+	}
+}
+
+// TestGetSnippet_PythonIndentResolver_CapturesFullBody regresses the bug
+// where getSnippet's startLineZero computation landed one line before the
+// declaration: for a dedent-delimited language that silently turned into an
+// empty snippet with LineFrom == LineTo instead of the function's body.
+func TestGetSnippet_PythonIndentResolver_CapturesFullBody(t *testing.T) {
+	text := "def foo():\n    return 1\n\n\ndef bar():\n    x = 1\n    return x\n"
+	doc := &scip.Document{
+		RelativePath: "pkg/mod.py",
+		Text:         text,
+		Occurrences:  []*scip.Occurrence{definitionOccurrence("bar", 4)},
+	}
+	sym := &scip.SymbolInformation{Symbol: "bar"}
+
+	line, lineFrom, lineTo, snippet, err := getSnippet(doc, sym)
+	if err != nil {
+		t.Fatalf("getSnippet returned error: %v", err)
+	}
+	if lineFrom == lineTo {
+		t.Fatalf("lineFrom == lineTo (%d), want the full def bar(): body captured", lineFrom)
+	}
+	if line != 4 || lineFrom != 4 {
+		t.Fatalf("line=%d lineFrom=%d, want 4 (the 0-based line of 'def bar():')", line, lineFrom)
+	}
+	if snippet == "" || !strings.Contains(snippet, "def bar():") || !strings.Contains(snippet, "return x") {
+		t.Fatalf("snippet = %q, want it to contain the full def bar(): body", snippet)
+	}
+	if strings.Contains(snippet, "return 1") {
+		t.Fatalf("snippet = %q, want it not to contain foo's body", snippet)
+	}
+}
+
+// TestGetSnippet_BraceResolver_DoesNotPrependSpuriousLine regresses the same
+// off-by-one for brace-delimited languages: the snippet must start at the
+// declaration line itself, not the line before it.
+func TestGetSnippet_BraceResolver_DoesNotPrependSpuriousLine(t *testing.T) {
+	text := "// header comment\nfunction foo() {\n  return 1;\n}\n\nfunction bar() {\n  return 2;\n}\n"
+	doc := &scip.Document{
+		RelativePath: "pkg/mod.js",
+		Text:         text,
+		Occurrences:  []*scip.Occurrence{definitionOccurrence("bar", 5)},
+	}
+	sym := &scip.SymbolInformation{Symbol: "bar"}
+
+	_, lineFrom, _, snippet, err := getSnippet(doc, sym)
+	if err != nil {
+		t.Fatalf("getSnippet returned error: %v", err)
+	}
+	if lineFrom != 5 {
+		t.Fatalf("lineFrom = %d, want 5 (the 0-based line of 'function bar() {')", lineFrom)
+	}
+	if !strings.HasPrefix(snippet, "function bar() {") {
+		t.Fatalf("snippet = %q, want it to start at 'function bar() {' with no spurious leading line", snippet)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sourcegraph/scip/bindings/go/scip"
+)
+
+func occAt(symbol string, roles scip.SymbolRole, startLine, endLine int32) *scip.Occurrence {
+	return &scip.Occurrence{
+		Symbol:      symbol,
+		SymbolRoles: int32(roles),
+		Range:       []int32{startLine, 0, endLine, 0},
+	}
+}
+
+// TestAddDocument_AttributesToNarrowestEnclosingDefinition regresses caller
+// attribution when one definition is nested inside another: a reference
+// inside the inner definition's span (which is also inside the outer
+// definition's span) must be attributed to the inner definition, not the
+// outer one, since that's the function that actually made the call.
+func TestAddDocument_AttributesToNarrowestEnclosingDefinition(t *testing.T) {
+	doc := &scip.Document{
+		RelativePath: "pkg/nested.go",
+		Occurrences: []*scip.Occurrence{
+			occAt("outer", scip.SymbolRole_Definition, 0, 10),
+			occAt("inner", scip.SymbolRole_Definition, 2, 5),
+			occAt("helper", 0, 3, 3),
+		},
+	}
+
+	ref := &referenceIndex{
+		callers:     make(map[string][]SymbolRef),
+		callees:     make(map[string][]SymbolRef),
+		occurrences: make(map[string][]OccurrenceSite),
+		truncated:   make(map[string]int),
+	}
+
+	if err := ref.addDocument(doc); err != nil {
+		t.Fatalf("addDocument returned error: %v", err)
+	}
+
+	innerCallees := ref.callees["inner"]
+	if len(innerCallees) != 1 || innerCallees[0].Name != "helper" {
+		t.Fatalf("callees[inner] = %v, want exactly one entry naming helper", innerCallees)
+	}
+
+	if outerCallees := ref.callees["outer"]; len(outerCallees) != 0 {
+		t.Fatalf("callees[outer] = %v, want empty (the reference belongs to the narrower inner definition)", outerCallees)
+	}
+
+	helperCallers := ref.callers["helper"]
+	if len(helperCallers) != 1 || helperCallers[0].Name != "inner" {
+		t.Fatalf("callers[helper] = %v, want exactly one entry naming inner", helperCallers)
+	}
+}
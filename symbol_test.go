@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+// TestParseSymbol locks in the namespace/receiver/name split for
+// the descriptor shapes parseSymbol is meant to handle: a Go method (Type
+// receiver + Method), a Rust impl member (Type receiver + Term), and a Java
+// inner class (Namespace + Type, no receiver).
+func TestParseSymbol(t *testing.T) {
+	cases := []struct {
+		name           string
+		symbol         string
+		wantScheme     string
+		wantManager    string
+		wantPackage    string
+		wantVersion    string
+		wantNamespaces []string
+		wantReceiver   string
+		wantName       string
+	}{
+		{
+			name:        "Go method",
+			symbol:      "scip-go gomod github.com/x/y v1.0.0 Foo#Bar().",
+			wantScheme:  "scip-go",
+			wantManager: "gomod",
+			wantPackage: "github.com/x/y",
+			wantVersion: "v1.0.0",
+			wantReceiver: "Foo",
+			wantName:     "Bar",
+		},
+		{
+			name:        "Rust impl member",
+			symbol:      "scip-rust cargo mycrate 1.0.0 MyStruct#field.",
+			wantScheme:  "scip-rust",
+			wantManager: "cargo",
+			wantPackage: "mycrate",
+			wantVersion: "1.0.0",
+			wantReceiver: "MyStruct",
+			wantName:     "field",
+		},
+		{
+			name:           "Java inner class",
+			symbol:         "scip-java maven com.foo:bar 1.0 Outer/Inner#",
+			wantScheme:     "scip-java",
+			wantManager:    "maven",
+			wantPackage:    "com.foo:bar",
+			wantVersion:    "1.0",
+			wantNamespaces: []string{"Outer"},
+			wantReceiver:   "",
+			wantName:       "Inner",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseSymbol(tc.symbol)
+			if err != nil {
+				t.Fatalf("parseSymbol(%q) returned error: %v", tc.symbol, err)
+			}
+			if got.Scheme != tc.wantScheme {
+				t.Errorf("Scheme = %q, want %q", got.Scheme, tc.wantScheme)
+			}
+			if got.Manager != tc.wantManager {
+				t.Errorf("Manager = %q, want %q", got.Manager, tc.wantManager)
+			}
+			if got.PackageName != tc.wantPackage {
+				t.Errorf("PackageName = %q, want %q", got.PackageName, tc.wantPackage)
+			}
+			if got.Version != tc.wantVersion {
+				t.Errorf("Version = %q, want %q", got.Version, tc.wantVersion)
+			}
+			if got.Receiver != tc.wantReceiver {
+				t.Errorf("Receiver = %q, want %q", got.Receiver, tc.wantReceiver)
+			}
+			if got.Name != tc.wantName {
+				t.Errorf("Name = %q, want %q", got.Name, tc.wantName)
+			}
+			if len(got.Namespaces) != len(tc.wantNamespaces) {
+				t.Errorf("Namespaces = %v, want %v", got.Namespaces, tc.wantNamespaces)
+			} else {
+				for i := range got.Namespaces {
+					if got.Namespaces[i] != tc.wantNamespaces[i] {
+						t.Errorf("Namespaces = %v, want %v", got.Namespaces, tc.wantNamespaces)
+						break
+					}
+				}
+			}
+		})
+	}
+}